@@ -0,0 +1,405 @@
+// Package azure implements a DNS provider for solving the DNS-01 challenge using azure DNS.
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/dns/armdns"
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/platform/config/env"
+)
+
+// Environment variables names.
+const (
+	envNamespace = "AZURE_"
+
+	EnvEnvironment    = envNamespace + "ENVIRONMENT"
+	EnvSubscriptionID = envNamespace + "SUBSCRIPTION_ID"
+	EnvResourceGroup  = envNamespace + "RESOURCE_GROUP"
+	EnvTenantID       = envNamespace + "TENANT_ID"
+	EnvClientID       = envNamespace + "CLIENT_ID"
+	EnvClientSecret   = envNamespace + "CLIENT_SECRET"
+	EnvPrivateZone    = envNamespace + "PRIVATE_ZONE"
+	EnvZoneName       = envNamespace + "ZONE_NAME"
+
+	EnvMetadataEndpoint = envNamespace + "METADATA_ENDPOINT"
+
+	EnvTTL                = envNamespace + "TTL"
+	EnvPropagationTimeout = envNamespace + "PROPAGATION_TIMEOUT"
+	EnvPollingInterval    = envNamespace + "POLLING_INTERVAL"
+)
+
+// defaultMetadataEndpoint is the well-known address of the Azure Instance Metadata Service.
+const defaultMetadataEndpoint = "http://169.254.169.254"
+
+// Config is used to configure the creation of the DNSProvider.
+//
+// When ClientID, ClientSecret and TenantID are all set, getCredential authenticates with
+// them directly via azidentity.NewClientSecretCredential; this is what lets NewDNSProvider
+// honor AZURE_CLIENT_SECRET_FILE (and programmatically-built Configs) even though the
+// variable AZURE_CLIENT_SECRET itself may be unset. Otherwise it falls back to
+// azidentity.NewDefaultAzureCredential, which reads the same env var names itself and also
+// handles workload identity, managed identity, and the Azure CLI.
+type Config struct {
+	ClientID       string
+	ClientSecret   string
+	TenantID       string
+	SubscriptionID string
+	ResourceGroup  string
+	PrivateZone    bool
+
+	// Cloud selects the Azure cloud to target: Azure public (default), China, Government, ...
+	Cloud cloud.Configuration
+
+	// MetadataEndpoint is the Azure Instance Metadata Service endpoint used to look up
+	// SubscriptionID/TenantID on an Azure VM when they aren't configured explicitly.
+	MetadataEndpoint string
+
+	TTL                int
+	PropagationTimeout time.Duration
+	PollingInterval    time.Duration
+}
+
+// NewDefaultConfig returns a default configuration for the DNSProvider.
+func NewDefaultConfig() *Config {
+	return &Config{
+		TTL:                env.GetOrDefaultInt(EnvTTL, 60),
+		PropagationTimeout: env.GetOrDefaultSecond(EnvPropagationTimeout, 2*time.Minute),
+		PollingInterval:    env.GetOrDefaultSecond(EnvPollingInterval, 2*time.Second),
+		Cloud:              cloud.AzurePublic,
+		MetadataEndpoint:   env.GetOrDefaultString(EnvMetadataEndpoint, defaultMetadataEndpoint),
+	}
+}
+
+// DNSProvider implements the challenge.Provider interface for Azure DNS (public or private zones).
+type DNSProvider struct {
+	config   *Config
+	provider challenge.Provider
+}
+
+// NewDNSProvider returns a DNSProvider instance configured for Azure DNS.
+// Credentials are given by environment variables.
+func NewDNSProvider() (*DNSProvider, error) {
+	config := NewDefaultConfig()
+	config.SubscriptionID = env.GetOrFile(EnvSubscriptionID)
+	config.ResourceGroup = env.GetOrFile(EnvResourceGroup)
+	config.ClientID = env.GetOrFile(EnvClientID)
+	config.ClientSecret = env.GetOrFile(EnvClientSecret)
+	config.TenantID = env.GetOrFile(EnvTenantID)
+	config.PrivateZone = env.GetOrDefaultBool(EnvPrivateZone, false)
+
+	if name := env.GetOrFile(EnvEnvironment); name != "" {
+		cloudConfig, err := cloudConfigurationFromName(name)
+		if err != nil {
+			return nil, fmt.Errorf("azure: %w", err)
+		}
+		config.Cloud = cloudConfig
+	}
+
+	return NewDNSProviderConfig(config)
+}
+
+// NewDNSProviderConfig return a DNSProvider instance configured for Azure DNS.
+func NewDNSProviderConfig(config *Config) (*DNSProvider, error) {
+	if config == nil {
+		return nil, errors.New("azure: the configuration of the DNS provider is nil")
+	}
+
+	// Best-effort: on an Azure VM this fills in the subscription without requiring
+	// the user to set AZURE_SUBSCRIPTION_ID, the same way the managed identity path did
+	// before azidentity.NewDefaultAzureCredential took over authentication itself.
+	if config.SubscriptionID == "" || config.TenantID == "" {
+		populateFromInstanceMetadata(config)
+	}
+
+	credential, err := getCredential(config)
+	if err != nil {
+		return nil, fmt.Errorf("azure: unable to create a credential: %w", err)
+	}
+
+	if config.PrivateZone {
+		return &DNSProvider{config: config, provider: &dnsProviderPrivate{config: config, credential: credential}}, nil
+	}
+
+	return &DNSProvider{config: config, provider: &dnsProviderPublic{config: config, credential: credential}}, nil
+}
+
+// Present creates a TXT record to fulfill the dns-01 challenge.
+func (d *DNSProvider) Present(domain, token, keyAuth string) error {
+	return d.provider.Present(domain, token, keyAuth)
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+func (d *DNSProvider) CleanUp(domain, token, keyAuth string) error {
+	return d.provider.CleanUp(domain, token, keyAuth)
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+func (d *DNSProvider) Timeout() (timeout, interval time.Duration) {
+	return d.provider.Timeout()
+}
+
+// getCredential builds the azcore.TokenCredential used to authenticate ARM requests.
+// An explicit client secret, set either programmatically on Config or via AZURE_CLIENT_SECRET
+// (including the AZURE_CLIENT_SECRET_FILE convention), is used directly when ClientID and
+// TenantID are set as well; otherwise it falls back to azidentity.NewDefaultAzureCredential,
+// which transparently handles the ambient environment (CLI, workload identity, managed identity, ...).
+func getCredential(config *Config) (azcore.TokenCredential, error) {
+	clientOptions := azcore.ClientOptions{Cloud: config.Cloud}
+
+	if config.ClientID != "" && config.ClientSecret != "" && config.TenantID != "" {
+		return azidentity.NewClientSecretCredential(config.TenantID, config.ClientID, config.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: clientOptions})
+	}
+
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{ClientOptions: clientOptions})
+}
+
+// cloudConfigurationFromName maps the legacy go-autorest environment names to their
+// azcore/cloud equivalent, so AZURE_ENVIRONMENT keeps working across the SDK migration.
+func cloudConfigurationFromName(name string) (cloud.Configuration, error) {
+	switch name {
+	case "AZUREPUBLICCLOUD", "AzurePublicCloud":
+		return cloud.AzurePublic, nil
+	case "AZUREUSGOVERNMENTCLOUD", "AzureUSGovernmentCloud":
+		return cloud.AzureGovernment, nil
+	case "AZURECHINACLOUD", "AzureChinaCloud":
+		return cloud.AzureChina, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("unsupported environment %q", name)
+	}
+}
+
+// maxConcurrencyRetries is the total number of attempts (the first one included) that
+// Present/CleanUp make at a conditional record set write when they keep losing an
+// optimistic-concurrency race (HTTP 412).
+const maxConcurrencyRetries = 5
+
+// retryOnPreconditionFailed runs op, retrying with jittered backoff while it keeps failing
+// with HTTP 412 (ETag precondition failed), which happens when two challenges for the same
+// record set (e.g. a wildcard and its apex) race a read-modify-write.
+func retryOnPreconditionFailed(op func() error) error {
+	var err error
+	for attempt := 0; attempt < maxConcurrencyRetries; attempt++ {
+		err = op()
+		if err == nil {
+			return nil
+		}
+
+		if !isStatusCode(err, http.StatusPreconditionFailed) {
+			return err
+		}
+
+		time.Sleep(backoffDelay(attempt))
+	}
+
+	return fmt.Errorf("giving up after %d attempts due to concurrent record set updates: %w", maxConcurrencyRetries, err)
+}
+
+// isNotFound reports whether err is an azcore.ResponseError for HTTP 404.
+func isNotFound(err error) bool {
+	return isStatusCode(err, http.StatusNotFound)
+}
+
+// isStatusCode reports whether err is an azcore.ResponseError carrying the given HTTP status.
+func isStatusCode(err error, statusCode int) bool {
+	var respErr *azcore.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == statusCode
+}
+
+// backoffDelay returns an exponential delay with jitter for the given (zero-based) attempt.
+func backoffDelay(attempt int) time.Duration {
+	base := 200 * time.Millisecond << attempt
+	return base + time.Duration(rand.Int63n(int64(200*time.Millisecond)))
+}
+
+// ptrTo returns a pointer to v, for building the track-2 SDK's pointer-to-struct request models.
+func ptrTo[T any](v T) *T {
+	return &v
+}
+
+// dnsProviderPublic implements the challenge.Provider interface for Azure public DNS zones.
+type dnsProviderPublic struct {
+	config     *Config
+	credential azcore.TokenCredential
+}
+
+// Timeout returns the timeout and interval to use when checking for DNS propagation.
+func (d *dnsProviderPublic) Timeout() (timeout, interval time.Duration) {
+	return d.config.PropagationTimeout, d.config.PollingInterval
+}
+
+func (d *dnsProviderPublic) clientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: d.config.Cloud}}
+}
+
+// Present creates a TXT record to fulfill the dns-01 challenge.
+func (d *dnsProviderPublic) Present(domain, token, keyAuth string) error {
+	ctx := context.Background()
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	zone, err := d.getHostedZoneID(ctx, info.EffectiveFQDN)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	rsc, err := armdns.NewRecordSetsClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	subDomain, err := dns01.ExtractSubDomain(info.EffectiveFQDN, zone)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	err = retryOnPreconditionFailed(func() error {
+		// Get existing record set
+		var ifMatch, ifNoneMatch *string
+
+		rset, err := rsc.Get(ctx, d.config.ResourceGroup, zone, subDomain, armdns.RecordTypeTXT, nil)
+		switch {
+		case err == nil:
+			ifMatch = rset.Etag
+		case isNotFound(err):
+			ifNoneMatch = ptrTo("*")
+		default:
+			return err
+		}
+
+		// Construct unique TXT records using map
+		uniqRecords := map[string]struct{}{info.Value: {}}
+		if rset.Properties != nil {
+			for _, txtRecord := range rset.Properties.TxtRecords {
+				// Assume Value doesn't contain multiple strings
+				if len(txtRecord.Value) > 0 && txtRecord.Value[0] != nil {
+					uniqRecords[*txtRecord.Value[0]] = struct{}{}
+				}
+			}
+		}
+
+		var txtRecords []*armdns.TxtRecord
+		for txt := range uniqRecords {
+			txtRecords = append(txtRecords, &armdns.TxtRecord{Value: []*string{ptrTo(txt)}})
+		}
+
+		rec := armdns.RecordSet{
+			Name: ptrTo(subDomain),
+			Properties: &armdns.RecordSetProperties{
+				TTL:        ptrTo(int64(d.config.TTL)),
+				TxtRecords: txtRecords,
+			},
+		}
+
+		_, err = rsc.CreateOrUpdate(ctx, d.config.ResourceGroup, zone, subDomain, armdns.RecordTypeTXT, rec,
+			&armdns.RecordSetsClientCreateOrUpdateOptions{IfMatch: ifMatch, IfNoneMatch: ifNoneMatch})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+	return nil
+}
+
+// CleanUp removes the TXT record matching the specified parameters.
+func (d *dnsProviderPublic) CleanUp(domain, token, keyAuth string) error {
+	ctx := context.Background()
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+
+	zone, err := d.getHostedZoneID(ctx, info.EffectiveFQDN)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	subDomain, err := dns01.ExtractSubDomain(info.EffectiveFQDN, zone)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	rsc, err := armdns.NewRecordSetsClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
+	err = retryOnPreconditionFailed(func() error {
+		rset, err := rsc.Get(ctx, d.config.ResourceGroup, zone, subDomain, armdns.RecordTypeTXT, nil)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		etag := rset.Etag
+
+		var remaining []*armdns.TxtRecord
+		if rset.Properties != nil {
+			for _, txtRecord := range rset.Properties.TxtRecords {
+				if len(txtRecord.Value) > 0 && txtRecord.Value[0] != nil && *txtRecord.Value[0] == info.Value {
+					continue
+				}
+				remaining = append(remaining, txtRecord)
+			}
+		}
+
+		if len(remaining) == 0 {
+			_, err = rsc.Delete(ctx, d.config.ResourceGroup, zone, subDomain, armdns.RecordTypeTXT,
+				&armdns.RecordSetsClientDeleteOptions{IfMatch: etag})
+			return err
+		}
+
+		rec := armdns.RecordSet{
+			Name: ptrTo(subDomain),
+			Properties: &armdns.RecordSetProperties{
+				TTL:        rset.Properties.TTL,
+				TxtRecords: remaining,
+			},
+		}
+
+		_, err = rsc.CreateOrUpdate(ctx, d.config.ResourceGroup, zone, subDomain, armdns.RecordTypeTXT, rec,
+			&armdns.RecordSetsClientCreateOrUpdateOptions{IfMatch: etag})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+	return nil
+}
+
+// Checks that azure has a zone for this domain name.
+func (d *dnsProviderPublic) getHostedZoneID(ctx context.Context, fqdn string) (string, error) {
+	if zone := env.GetOrFile(EnvZoneName); zone != "" {
+		return zone, nil
+	}
+
+	authZone, err := dns01.FindZoneByFqdn(fqdn)
+	if err != nil {
+		return "", err
+	}
+
+	dc, err := armdns.NewZonesClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return "", err
+	}
+
+	zone, err := dc.Get(ctx, d.config.ResourceGroup, dns01.UnFqdn(authZone), nil)
+	if err != nil {
+		return "", err
+	}
+
+	// zone.Name shouldn't have a trailing dot(.)
+	if zone.Name == nil {
+		return "", errors.New("zone has no name")
+	}
+	return *zone.Name, nil
+}
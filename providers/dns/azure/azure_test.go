@@ -0,0 +1,78 @@
+package azure
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudConfigurationFromName(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		name     string
+		expected string
+	}{
+		{desc: "public cloud, legacy casing", name: "AzurePublicCloud", expected: "login.microsoftonline.com"},
+		{desc: "public cloud, upper casing", name: "AZUREPUBLICCLOUD", expected: "login.microsoftonline.com"},
+		{desc: "US government cloud", name: "AzureUSGovernmentCloud", expected: "login.microsoftonline.us"},
+		{desc: "China cloud", name: "AzureChinaCloud", expected: "login.chinacloudapi.cn"},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			cloudConfig, err := cloudConfigurationFromName(test.name)
+			require.NoError(t, err)
+			assert.Contains(t, cloudConfig.ActiveDirectoryAuthorityHost, test.expected)
+		})
+	}
+}
+
+func TestCloudConfigurationFromName_error(t *testing.T) {
+	_, err := cloudConfigurationFromName("AzureGermanCloud")
+	require.Error(t, err)
+}
+
+func TestRetryOnPreconditionFailed(t *testing.T) {
+	t.Run("succeeds without retrying on success", func(t *testing.T) {
+		calls := 0
+		err := retryOnPreconditionFailed(func() error {
+			calls++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("returns non-412 errors immediately", func(t *testing.T) {
+		calls := 0
+		wantErr := errors.New("boom")
+		err := retryOnPreconditionFailed(func() error {
+			calls++
+			return wantErr
+		})
+		require.ErrorIs(t, err, wantErr)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("retries 412 up to the limit then gives up", func(t *testing.T) {
+		calls := 0
+		preconditionFailed := &azcore.ResponseError{StatusCode: http.StatusPreconditionFailed}
+		err := retryOnPreconditionFailed(func() error {
+			calls++
+			return preconditionFailed
+		})
+		require.Error(t, err)
+		assert.Equal(t, maxConcurrencyRetries, calls)
+	})
+}
+
+func TestBackoffDelay(t *testing.T) {
+	for attempt := 0; attempt < 4; attempt++ {
+		d := backoffDelay(attempt)
+		assert.Greater(t, d.Nanoseconds(), int64(0))
+	}
+}
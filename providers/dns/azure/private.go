@@ -2,22 +2,94 @@ package azure
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/services/privatedns/mgmt/2018-09-01/privatedns"
-	"github.com/Azure/go-autorest/autorest"
-	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/privatedns/armprivatedns"
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/platform/config/env"
 )
 
+// resourceGroupCacheTTL bounds how long the zone-name to resource-group map built by
+// listResourceGroupsByZone is trusted before being rebuilt from the subscription.
+const resourceGroupCacheTTL = 5 * time.Minute
+
+// instanceMetadata is the subset of the IMDS `instance` document that we need
+// to fill in a Config when the user didn't set it explicitly.
+// See https://docs.microsoft.com/en-us/azure/virtual-machines/linux/instance-metadata-service.
+type instanceMetadata struct {
+	Compute struct {
+		SubscriptionID string `json:"subscriptionId"`
+		TenantID       string `json:"tenantId"`
+	} `json:"compute"`
+}
+
+// populateFromInstanceMetadata fills in Config.SubscriptionID/TenantID from the Azure
+// Instance Metadata Service when they aren't set explicitly. It is a best-effort lookup:
+// off of Azure (or with no managed identity available) the request simply fails and the
+// fields are left for azidentity / the user to resolve another way.
+func populateFromInstanceMetadata(config *Config) {
+	if config.SubscriptionID != "" && config.TenantID != "" {
+		return
+	}
+
+	meta, err := getInstanceMetadata(config.MetadataEndpoint)
+	if err != nil {
+		return
+	}
+
+	if config.SubscriptionID == "" {
+		config.SubscriptionID = meta.Compute.SubscriptionID
+	}
+	if config.TenantID == "" {
+		config.TenantID = meta.Compute.TenantID
+	}
+}
+
+// getInstanceMetadata fetches and decodes the `instance` document from the IMDS.
+func getInstanceMetadata(metadataEndpoint string) (*instanceMetadata, error) {
+	req, err := http.NewRequest(http.MethodGet, metadataEndpoint+"/metadata/instance?api-version=2017-08-01", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata", "true")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	meta := &instanceMetadata{}
+	if err := json.NewDecoder(resp.Body).Decode(meta); err != nil {
+		return nil, fmt.Errorf("unable to decode instance metadata: %w", err)
+	}
+
+	return meta, nil
+}
+
 // dnsProviderPrivate implements the challenge.Provider interface for Azure Private Zone DNS.
 type dnsProviderPrivate struct {
 	config     *Config
-	authorizer autorest.Authorizer
+	credential azcore.TokenCredential
+
+	// resourceGroupsMu guards resourceGroups and resourceGroupsExpiry, populated on demand
+	// by resolveResourceGroup when config.ResourceGroup isn't set explicitly.
+	resourceGroupsMu     sync.Mutex
+	resourceGroups       map[string]string // zone name -> resource group
+	resourceGroupsExpiry time.Time
 }
 
 // Timeout returns the timeout and interval to use when checking for DNS propagation.
@@ -26,6 +98,10 @@ func (d *dnsProviderPrivate) Timeout() (timeout, interval time.Duration) {
 	return d.config.PropagationTimeout, d.config.PollingInterval
 }
 
+func (d *dnsProviderPrivate) clientOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: azcore.ClientOptions{Cloud: d.config.Cloud}}
+}
+
 // Present creates a TXT record to fulfill the dns-01 challenge.
 func (d *dnsProviderPrivate) Present(domain, token, keyAuth string) error {
 	ctx := context.Background()
@@ -36,49 +112,63 @@ func (d *dnsProviderPrivate) Present(domain, token, keyAuth string) error {
 		return fmt.Errorf("azure: %w", err)
 	}
 
-	rsc := privatedns.NewRecordSetsClientWithBaseURI(d.config.ResourceManagerEndpoint, d.config.SubscriptionID)
-	rsc.Authorizer = d.authorizer
+	resourceGroup, err := d.resolveResourceGroup(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
 
-	subDomain, err := dns01.ExtractSubDomain(info.EffectiveFQDN, zone)
+	rsc, err := armprivatedns.NewRecordSetsClient(d.config.SubscriptionID, d.credential, d.clientOptions())
 	if err != nil {
 		return fmt.Errorf("azure: %w", err)
 	}
 
-	// Get existing record set
-	rset, err := rsc.Get(ctx, d.config.ResourceGroup, zone, privatedns.TXT, subDomain)
+	subDomain, err := dns01.ExtractSubDomain(info.EffectiveFQDN, zone)
 	if err != nil {
-		var detailed autorest.DetailedError
-		if !errors.As(err, &detailed) || detailed.StatusCode != http.StatusNotFound {
-			return fmt.Errorf("azure: %w", err)
-		}
+		return fmt.Errorf("azure: %w", err)
 	}
 
-	// Construct unique TXT records using map
-	uniqRecords := map[string]struct{}{info.Value: {}}
-	if rset.RecordSetProperties != nil && rset.TxtRecords != nil {
-		for _, txtRecord := range *rset.TxtRecords {
-			// Assume Value doesn't contain multiple strings
-			values := to.StringSlice(txtRecord.Value)
-			if len(values) > 0 {
-				uniqRecords[values[0]] = struct{}{}
+	err = retryOnPreconditionFailed(func() error {
+		// Get existing record set
+		var ifMatch, ifNoneMatch *string
+
+		rset, err := rsc.Get(ctx, resourceGroup, zone, armprivatedns.RecordTypeTXT, subDomain, nil)
+		switch {
+		case err == nil:
+			ifMatch = rset.Etag
+		case isNotFound(err):
+			ifNoneMatch = ptrTo("*")
+		default:
+			return err
+		}
+
+		// Construct unique TXT records using map
+		uniqRecords := map[string]struct{}{info.Value: {}}
+		if rset.Properties != nil {
+			for _, txtRecord := range rset.Properties.TxtRecords {
+				// Assume Value doesn't contain multiple strings
+				if len(txtRecord.Value) > 0 && txtRecord.Value[0] != nil {
+					uniqRecords[*txtRecord.Value[0]] = struct{}{}
+				}
 			}
 		}
-	}
 
-	var txtRecords []privatedns.TxtRecord
-	for txt := range uniqRecords {
-		txtRecords = append(txtRecords, privatedns.TxtRecord{Value: &[]string{txt}})
-	}
+		var txtRecords []*armprivatedns.TxtRecord
+		for txt := range uniqRecords {
+			txtRecords = append(txtRecords, &armprivatedns.TxtRecord{Value: []*string{ptrTo(txt)}})
+		}
 
-	rec := privatedns.RecordSet{
-		Name: &subDomain,
-		RecordSetProperties: &privatedns.RecordSetProperties{
-			TTL:        to.Int64Ptr(int64(d.config.TTL)),
-			TxtRecords: &txtRecords,
-		},
-	}
+		rec := armprivatedns.RecordSet{
+			Name: ptrTo(subDomain),
+			Properties: &armprivatedns.RecordSetProperties{
+				TTL:        ptrTo(int64(d.config.TTL)),
+				TxtRecords: txtRecords,
+			},
+		}
 
-	_, err = rsc.CreateOrUpdate(ctx, d.config.ResourceGroup, zone, privatedns.TXT, subDomain, rec, "", "")
+		_, err = rsc.CreateOrUpdate(ctx, resourceGroup, zone, armprivatedns.RecordTypeTXT, subDomain, rec,
+			&armprivatedns.RecordSetsClientCreateOrUpdateOptions{IfMatch: ifMatch, IfNoneMatch: ifNoneMatch})
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("azure: %w", err)
 	}
@@ -95,15 +185,60 @@ func (d *dnsProviderPrivate) CleanUp(domain, token, keyAuth string) error {
 		return fmt.Errorf("azure: %w", err)
 	}
 
+	resourceGroup, err := d.resolveResourceGroup(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
+
 	subDomain, err := dns01.ExtractSubDomain(info.EffectiveFQDN, zone)
 	if err != nil {
 		return fmt.Errorf("azure: %w", err)
 	}
 
-	rsc := privatedns.NewRecordSetsClientWithBaseURI(d.config.ResourceManagerEndpoint, d.config.SubscriptionID)
-	rsc.Authorizer = d.authorizer
+	rsc, err := armprivatedns.NewRecordSetsClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return fmt.Errorf("azure: %w", err)
+	}
 
-	_, err = rsc.Delete(ctx, d.config.ResourceGroup, zone, privatedns.TXT, subDomain, "")
+	err = retryOnPreconditionFailed(func() error {
+		rset, err := rsc.Get(ctx, resourceGroup, zone, armprivatedns.RecordTypeTXT, subDomain, nil)
+		if err != nil {
+			if isNotFound(err) {
+				return nil
+			}
+			return err
+		}
+
+		etag := rset.Etag
+
+		var remaining []*armprivatedns.TxtRecord
+		if rset.Properties != nil {
+			for _, txtRecord := range rset.Properties.TxtRecords {
+				if len(txtRecord.Value) > 0 && txtRecord.Value[0] != nil && *txtRecord.Value[0] == info.Value {
+					continue
+				}
+				remaining = append(remaining, txtRecord)
+			}
+		}
+
+		if len(remaining) == 0 {
+			_, err = rsc.Delete(ctx, resourceGroup, zone, armprivatedns.RecordTypeTXT, subDomain,
+				&armprivatedns.RecordSetsClientDeleteOptions{IfMatch: etag})
+			return err
+		}
+
+		rec := armprivatedns.RecordSet{
+			Name: ptrTo(subDomain),
+			Properties: &armprivatedns.RecordSetProperties{
+				TTL:        rset.Properties.TTL,
+				TxtRecords: remaining,
+			},
+		}
+
+		_, err = rsc.CreateOrUpdate(ctx, resourceGroup, zone, armprivatedns.RecordTypeTXT, subDomain, rec,
+			&armprivatedns.RecordSetsClientCreateOrUpdateOptions{IfMatch: etag})
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("azure: %w", err)
 	}
@@ -121,14 +256,104 @@ func (d *dnsProviderPrivate) getHostedZoneID(ctx context.Context, fqdn string) (
 		return "", err
 	}
 
-	dc := privatedns.NewPrivateZonesClientWithBaseURI(d.config.ResourceManagerEndpoint, d.config.SubscriptionID)
-	dc.Authorizer = d.authorizer
+	zoneName := dns01.UnFqdn(authZone)
 
-	zone, err := dc.Get(ctx, d.config.ResourceGroup, dns01.UnFqdn(authZone))
+	resourceGroup, err := d.resolveResourceGroup(ctx, zoneName)
+	if err != nil {
+		return "", err
+	}
+
+	dc, err := armprivatedns.NewPrivateZonesClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return "", err
+	}
+
+	zone, err := dc.Get(ctx, resourceGroup, zoneName, nil)
 	if err != nil {
 		return "", err
 	}
 
 	// zone.Name shouldn't have a trailing dot(.)
-	return to.String(zone.Name), nil
+	if zone.Name == nil {
+		return "", fmt.Errorf("private zone %q has no name", zoneName)
+	}
+	return *zone.Name, nil
+}
+
+// resolveResourceGroup returns the resource group hosting the given private zone.
+// It uses the explicitly configured ResourceGroup when set; otherwise it consults
+// (and, if stale, rebuilds) a subscription-wide cache of zone name to resource group.
+func (d *dnsProviderPrivate) resolveResourceGroup(ctx context.Context, zoneName string) (string, error) {
+	if d.config.ResourceGroup != "" {
+		return d.config.ResourceGroup, nil
+	}
+
+	d.resourceGroupsMu.Lock()
+	defer d.resourceGroupsMu.Unlock()
+
+	if d.resourceGroups == nil || time.Now().After(d.resourceGroupsExpiry) {
+		resourceGroups, err := d.listResourceGroupsByZone(ctx)
+		if err != nil {
+			return "", err
+		}
+		d.resourceGroups = resourceGroups
+		d.resourceGroupsExpiry = time.Now().Add(resourceGroupCacheTTL)
+	}
+
+	resourceGroup, ok := d.resourceGroups[zoneName]
+	if !ok {
+		return "", fmt.Errorf("no private zone named %q was found in subscription %q, set %s to override", zoneName, d.config.SubscriptionID, EnvResourceGroup)
+	}
+
+	return resourceGroup, nil
+}
+
+// listResourceGroupsByZone lists every private zone in the subscription and returns a
+// map of zone name to the resource group that hosts it.
+func (d *dnsProviderPrivate) listResourceGroupsByZone(ctx context.Context) (map[string]string, error) {
+	pzc, err := armprivatedns.NewPrivateZonesClient(d.config.SubscriptionID, d.credential, d.clientOptions())
+	if err != nil {
+		return nil, err
+	}
+
+	resourceGroups := map[string]string{}
+
+	pager := pzc.NewListPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list private zones: %w", err)
+		}
+
+		for _, zone := range page.Value {
+			if zone.Name == nil || zone.ID == nil {
+				continue
+			}
+
+			resourceGroup, err := resourceGroupFromID(*zone.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			if existing, ok := resourceGroups[*zone.Name]; ok && existing != resourceGroup {
+				return nil, fmt.Errorf("private zone %q exists in multiple resource groups (%q and %q), set %s to disambiguate", *zone.Name, existing, resourceGroup, EnvResourceGroup)
+			}
+
+			resourceGroups[*zone.Name] = resourceGroup
+		}
+	}
+
+	return resourceGroups, nil
+}
+
+// resourceGroupFromID extracts the resource group segment from an ARM resource ID,
+// e.g. "/subscriptions/x/resourceGroups/y/providers/...".
+func resourceGroupFromID(id string) (string, error) {
+	parts := strings.Split(id, "/")
+	for i, part := range parts {
+		if strings.EqualFold(part, "resourceGroups") && i+1 < len(parts) {
+			return parts[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("unable to parse resource group from resource id %q", id)
 }
@@ -0,0 +1,67 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceGroupFromID(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		id       string
+		expected string
+	}{
+		{
+			desc:     "canonical segment casing",
+			id:       "/subscriptions/sub-id/resourceGroups/my-rg/providers/Microsoft.Network/privateDnsZones/example.com",
+			expected: "my-rg",
+		},
+		{
+			desc:     "case-insensitive segment name",
+			id:       "/subscriptions/sub-id/RESOURCEGROUPS/my-rg/providers/Microsoft.Network/privateDnsZones/example.com",
+			expected: "my-rg",
+		},
+		{
+			desc:     "trailing slash after the resource group",
+			id:       "/subscriptions/sub-id/resourceGroups/my-rg/",
+			expected: "my-rg",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			rg, err := resourceGroupFromID(test.id)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, rg)
+		})
+	}
+}
+
+func TestResourceGroupFromID_error(t *testing.T) {
+	testCases := []struct {
+		desc string
+		id   string
+	}{
+		{
+			desc: "missing resourceGroups segment",
+			id:   "/subscriptions/sub-id/providers/Microsoft.Network/privateDnsZones/example.com",
+		},
+		{
+			desc: "resourceGroups is the last segment",
+			id:   "/subscriptions/sub-id/resourceGroups",
+		},
+		{
+			desc: "empty id",
+			id:   "",
+		},
+	}
+
+	for _, test := range testCases {
+		t.Run(test.desc, func(t *testing.T) {
+			_, err := resourceGroupFromID(test.id)
+			require.Error(t, err)
+		})
+	}
+}